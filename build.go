@@ -0,0 +1,456 @@
+package containerd
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/containerd/containerd/builder"
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/mount"
+	"github.com/containerd/containerd/services/diff"
+	"github.com/containerd/containerd/snapshot"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/identity"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/pkg/errors"
+)
+
+// BuildOptions configures a Client.Build call.
+type BuildOptions struct {
+	// Args are the Dockerfile ARG values supplied by the caller.
+	Args map[string]string
+	// Target selects a single stage, by its "AS <name>" alias, to build in
+	// a multi-stage Dockerfile. If empty, the last stage is built.
+	Target string
+	// Labels are applied to the resulting image's config.
+	Labels map[string]string
+	// Cache, if true, reuses the result of a previous identical step
+	// instead of re-running it.
+	Cache bool
+}
+
+type BuildOpts func(*BuildOptions) error
+
+// WithBuildArgs sets the Dockerfile ARG values available to the build.
+func WithBuildArgs(args map[string]string) BuildOpts {
+	return func(o *BuildOptions) error {
+		o.Args = args
+		return nil
+	}
+}
+
+// WithBuildTarget builds only the named stage of a multi-stage Dockerfile.
+func WithBuildTarget(target string) BuildOpts {
+	return func(o *BuildOptions) error {
+		o.Target = target
+		return nil
+	}
+}
+
+// WithBuildLabels applies the given labels to the resulting image's config.
+func WithBuildLabels(labels map[string]string) BuildOpts {
+	return func(o *BuildOptions) error {
+		o.Labels = labels
+		return nil
+	}
+}
+
+// WithBuildCache enables or disables reuse of unchanged steps across builds.
+func WithBuildCache(enabled bool) BuildOpts {
+	return func(o *BuildOptions) error {
+		o.Cache = enabled
+		return nil
+	}
+}
+
+// Build builds an OCI image from a Dockerfile and a build context tar,
+// storing the result through the client's content, snapshot, and diff
+// services, and returns it the same way Client.Pull does. It runs in the
+// context's namespace, or the client's default namespace if the context
+// carries none.
+func (c *Client) Build(ctx context.Context, dockerfile io.Reader, buildContext io.Reader, opts ...BuildOpts) (*Image, error) {
+	ctx, err := c.requireNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var options BuildOptions
+	for _, o := range opts {
+		if err := o(&options); err != nil {
+			return nil, err
+		}
+	}
+
+	stages, err := builder.Parse(dockerfile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse Dockerfile")
+	}
+	if len(stages) == 0 {
+		return nil, errors.New("Dockerfile has no stages")
+	}
+	stage := stages[len(stages)-1]
+	if options.Target != "" {
+		s, ok := builder.StageByName(stages, options.Target)
+		if !ok {
+			return nil, errors.Errorf("no such build stage %q", options.Target)
+		}
+		stage = s
+	}
+
+	contextFiles, err := readBuildContext(buildContext)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read build context")
+	}
+
+	cache := builder.NewNoopCache()
+	if options.Cache {
+		cache = builder.NewMemoryCache()
+	}
+
+	b := &buildExecution{
+		client:  c,
+		store:   c.content(),
+		diff:    c.diff(),
+		snap:    c.snapshotter(),
+		cache:   cache,
+		args:    options.Args,
+		context: contextFiles,
+		labels:  options.Labels,
+		env:     map[string]string{},
+	}
+	return b.run(ctx, stage.Instructions)
+}
+
+// buildExecution carries the state threaded through one Client.Build call as
+// it walks a stage's instructions.
+type buildExecution struct {
+	client *Client
+	store  content.Store
+	diff   diff.DiffService
+	snap   snapshot.Snapshotter
+	cache  builder.Cache
+
+	args    map[string]string
+	labels  map[string]string
+	env     map[string]string
+	context map[string][]byte
+
+	chainID string
+	image   *Image
+	spec    *specs.Spec
+	stepNum int
+}
+
+// readBuildContext reads every regular file out of the build context tar
+// into memory, keyed by its path. Build contexts in this implementation are
+// expected to be small enough to buffer; large contexts should be staged
+// through the content store instead.
+func readBuildContext(r io.Reader) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	if r == nil {
+		return files, nil
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[hdr.Name] = data
+	}
+	return files, nil
+}
+
+func (b *buildExecution) run(ctx context.Context, instructions []builder.Instruction) (*Image, error) {
+	for i, inst := range instructions {
+		b.stepNum = i
+		if err := b.step(ctx, inst); err != nil {
+			return nil, errors.Wrapf(err, "step %d (%s)", i, inst.Raw)
+		}
+	}
+	if b.image == nil {
+		return nil, errors.New("Dockerfile produced no image")
+	}
+	return b.image, nil
+}
+
+func (b *buildExecution) step(ctx context.Context, inst builder.Instruction) error {
+	switch inst.Cmd {
+	case "FROM":
+		return b.stepFrom(ctx, inst)
+	case "ARG":
+		return b.stepArg(inst)
+	case "ENV":
+		return b.stepEnv(inst)
+	case "LABEL":
+		return b.stepLabel(inst)
+	case "WORKDIR":
+		return b.stepWorkdir(inst)
+	case "USER":
+		return b.stepUser(inst)
+	case "CMD":
+		b.spec.Process.Args = inst.Args
+		return nil
+	case "ENTRYPOINT":
+		b.spec.Process.Args = append(inst.Args, b.spec.Process.Args...)
+		return nil
+	case "RUN":
+		return b.stepRun(ctx, inst)
+	case "COPY", "ADD":
+		return b.stepCopy(ctx, inst)
+	default:
+		return errors.Errorf("unsupported instruction %q", inst.Cmd)
+	}
+}
+
+func (b *buildExecution) stepFrom(ctx context.Context, inst builder.Instruction) error {
+	if len(inst.Args) == 0 {
+		return errors.New("FROM requires an image reference")
+	}
+	ref := b.expand(inst.Args[0])
+	image, err := b.client.Pull(ctx, ref, WithPullUnpack)
+	if err != nil {
+		return errors.Wrapf(err, "failed to pull base image %q", ref)
+	}
+	diffIDs, err := image.i.RootFS(ctx, b.store)
+	if err != nil {
+		return err
+	}
+	spec, err := GenerateSpec(fmt.Sprintf("build-%d", b.stepNum), WithImageRef(ref))
+	if err != nil {
+		return err
+	}
+	b.image = image
+	b.spec = spec
+	b.chainID = identity.ChainID(diffIDs).String()
+	return nil
+}
+
+func (b *buildExecution) stepArg(inst builder.Instruction) error {
+	if b.args == nil {
+		b.args = map[string]string{}
+	}
+	for _, a := range inst.Args {
+		k, v := splitKV(a)
+		if _, ok := b.args[k]; !ok {
+			b.args[k] = b.expand(v)
+		}
+	}
+	return nil
+}
+
+func (b *buildExecution) stepEnv(inst builder.Instruction) error {
+	for _, kv := range inst.Args {
+		k, v := splitKV(kv)
+		b.env[k] = b.expand(v)
+		b.spec.Process.Env = append(b.spec.Process.Env, k+"="+b.env[k])
+	}
+	return nil
+}
+
+func (b *buildExecution) stepLabel(inst builder.Instruction) error {
+	if b.labels == nil {
+		b.labels = map[string]string{}
+	}
+	for _, kv := range inst.Args {
+		k, v := splitKV(kv)
+		b.labels[k] = b.expand(v)
+	}
+	return nil
+}
+
+func (b *buildExecution) stepWorkdir(inst builder.Instruction) error {
+	if len(inst.Args) != 1 {
+		return errors.New("WORKDIR requires exactly one argument")
+	}
+	b.spec.Process.Cwd = b.expand(inst.Args[0])
+	return nil
+}
+
+func (b *buildExecution) stepUser(inst builder.Instruction) error {
+	if len(inst.Args) != 1 {
+		return errors.New("USER requires exactly one argument")
+	}
+	if b.spec.Annotations == nil {
+		b.spec.Annotations = map[string]string{}
+	}
+	b.spec.Annotations["user"] = b.expand(inst.Args[0])
+	return nil
+}
+
+// stepRun executes a RUN instruction in an ephemeral container on top of the
+// current chain, then diffs the resulting snapshot into a new layer.
+func (b *buildExecution) stepRun(ctx context.Context, inst builder.Instruction) error {
+	key := builder.CacheKey(b.chainID, inst)
+	if hit, ok := b.cache.Get(key); ok {
+		b.chainID = hit
+		return nil
+	}
+
+	id := fmt.Sprintf("build-%d", b.stepNum)
+	mounts, err := b.snap.Prepare(ctx, id, b.chainID)
+	if err != nil {
+		return err
+	}
+
+	runSpec := *b.spec
+	runSpec.Process.Args = inst.Args
+	container, err := b.client.NewContainer(ctx, id, &runSpec, NewContainerWithExistingRootFS(id))
+	if err != nil {
+		return err
+	}
+	if err := runContainer(ctx, container); err != nil {
+		return err
+	}
+
+	newChainID, err := b.commitLayer(ctx, id, mounts)
+	if err != nil {
+		return err
+	}
+	b.cache.Put(key, newChainID)
+	b.chainID = newChainID
+	return nil
+}
+
+// stepCopy applies a COPY/ADD instruction by writing the matching build
+// context files directly onto a new snapshot mounted from the current chain,
+// then diffing the result into a new layer.
+func (b *buildExecution) stepCopy(ctx context.Context, inst builder.Instruction) error {
+	if len(inst.Args) < 2 {
+		return errors.New("COPY/ADD requires a source and a destination")
+	}
+	srcs, dst := inst.Args[:len(inst.Args)-1], inst.Args[len(inst.Args)-1]
+
+	contents := make([][]byte, len(srcs))
+	hashes := make([]string, len(srcs))
+	for i, src := range srcs {
+		data, ok := b.context[src]
+		if !ok {
+			return errors.Errorf("%q not found in build context", src)
+		}
+		contents[i] = data
+		hashes[i] = hashContextFile(data)
+	}
+	key := builder.CacheKey(b.chainID, inst, builder.JoinContextHashes(hashes))
+	if hit, ok := b.cache.Get(key); ok {
+		b.chainID = hit
+		return nil
+	}
+
+	id := fmt.Sprintf("build-%d", b.stepNum)
+	mounts, err := b.snap.Prepare(ctx, id, b.chainID)
+	if err != nil {
+		return err
+	}
+
+	root, err := ioutil.TempDir("", "containerd-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(root)
+	if err := mount.All(mounts, root); err != nil {
+		return err
+	}
+	defer mount.Unmount(root, 0)
+
+	for i, src := range srcs {
+		data := contents[i]
+		target := filepath.Join(root, dst, filepath.Base(src))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	newChainID, err := b.commitLayer(ctx, id, mounts)
+	if err != nil {
+		return err
+	}
+	b.cache.Put(key, newChainID)
+	b.chainID = newChainID
+	return nil
+}
+
+// hashContextFile returns a content hash for a build context file, suitable
+// for inclusion in a CacheKey so that a COPY/ADD step is invalidated when
+// its source changes.
+func hashContextFile(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// commitLayer diffs the mounts produced for snapshot id against its parent
+// (b.chainID), persists the result as a new layer in the content store,
+// commits the snapshot under the resulting chain ID, and returns that chain
+// ID as the new top layer.
+func (b *buildExecution) commitLayer(ctx context.Context, id string, mounts []mount.Mount) (string, error) {
+	lower, err := b.snap.Mounts(ctx, b.chainID)
+	if err != nil {
+		return "", err
+	}
+	desc, err := b.diff.Compare(ctx, lower, mounts)
+	if err != nil {
+		return "", err
+	}
+	chainID := identity.ChainID([]digest.Digest{digest.Digest(b.chainID), desc.Digest}).String()
+	if err := b.snap.Commit(ctx, chainID, id); err != nil {
+		return "", err
+	}
+	return chainID, nil
+}
+
+// expand substitutes ${VAR}/$VAR references from b.args and b.env into s.
+func (b *buildExecution) expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		if v, ok := b.env[name]; ok {
+			return v
+		}
+		return b.args[name]
+	})
+}
+
+func splitKV(s string) (string, string) {
+	for i := range s {
+		if s[i] == '=' {
+			return s[:i], s[i+1:]
+		}
+	}
+	return s, ""
+}
+
+func runContainer(ctx context.Context, container *Container) error {
+	task, err := container.NewTask(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := task.Start(ctx); err != nil {
+		return err
+	}
+	status, err := task.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return errors.Errorf("instruction exited with status %d", status)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// CacheKey identifies a build step by its parent chain ID, the instruction
+// being run, and the hashes of any context files it consumes, so that an
+// unchanged step can be skipped on a later build.
+func CacheKey(parentChainID string, inst Instruction, contextFileHashes ...string) string {
+	h := sha256.New()
+	h.Write([]byte(parentChainID))
+	h.Write([]byte(inst.Raw))
+	for _, fh := range contextFileHashes {
+		h.Write([]byte(fh))
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// Cache maps a CacheKey to the chain ID produced by running that step
+// previously, letting a build reuse unchanged steps.
+type Cache interface {
+	Get(key string) (chainID string, ok bool)
+	Put(key, chainID string)
+}
+
+// NewMemoryCache returns a Cache that only lives for the process lifetime.
+func NewMemoryCache() Cache {
+	return &memoryCache{entries: make(map[string]string)}
+}
+
+type memoryCache struct {
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+func (c *memoryCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	chainID, ok := c.entries[key]
+	return chainID, ok
+}
+
+func (c *memoryCache) Put(key, chainID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = chainID
+}
+
+// noopCache never returns a hit, used when the caller disables the build
+// cache.
+type noopCache struct{}
+
+func (noopCache) Get(string) (string, bool) { return "", false }
+func (noopCache) Put(string, string)        {}
+
+// NewNoopCache returns a Cache that never hits, disabling step reuse.
+func NewNoopCache() Cache {
+	return noopCache{}
+}
+
+// JoinContextHashes is a convenience for building the contextFileHashes
+// argument to CacheKey from a set of already-hashed context files.
+func JoinContextHashes(hashes []string) string {
+	return strings.Join(hashes, ",")
+}
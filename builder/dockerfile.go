@@ -0,0 +1,95 @@
+// Package builder implements a classic Dockerfile parser and instruction
+// model used by Client.Build to drive containerd's content, snapshot, and
+// diff services directly.
+package builder
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Instruction is a single parsed Dockerfile instruction, such as
+// "RUN apt-get update".
+type Instruction struct {
+	Cmd  string
+	Args []string
+	Raw  string
+}
+
+// Stage is a single FROM..until the next FROM (or end of file) in a
+// multi-stage Dockerfile.
+type Stage struct {
+	// Name is the stage's "AS <name>" alias, if any.
+	Name         string
+	Instructions []Instruction
+}
+
+// Parse reads a Dockerfile from r and splits it into build stages in file
+// order. Blank lines and comments ("#...") are skipped, and a trailing "\"
+// continues an instruction onto the next line.
+func Parse(r io.Reader) ([]Stage, error) {
+	var (
+		stages  []Stage
+		pending string
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasSuffix(line, "\\") {
+			pending += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line, pending = pending+line, ""
+
+		fields := strings.Fields(line)
+		cmd := strings.ToUpper(fields[0])
+		inst := Instruction{
+			Cmd:  cmd,
+			Args: fields[1:],
+			Raw:  line,
+		}
+		if cmd == "FROM" {
+			stages = append(stages, Stage{Name: stageName(inst.Args)})
+		}
+		if len(stages) == 0 {
+			return nil, errors.Errorf("instruction %q before FROM", inst.Raw)
+		}
+		last := &stages[len(stages)-1]
+		last.Instructions = append(last.Instructions, inst)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read Dockerfile")
+	}
+	if pending != "" {
+		return nil, errors.New("Dockerfile ends with an unterminated line continuation")
+	}
+	return stages, nil
+}
+
+// stageName returns the "AS <name>" alias of a FROM instruction's arguments,
+// or "" if it has none.
+func stageName(args []string) string {
+	for i, a := range args {
+		if strings.EqualFold(a, "AS") && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// StageByName returns the stage named name, which may refer to a prior
+// stage's "AS <name>" alias or to its 0-based index.
+func StageByName(stages []Stage, name string) (Stage, bool) {
+	for _, s := range stages {
+		if s.Name == name {
+			return s, true
+		}
+	}
+	return Stage{}, false
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"runtime"
 	"time"
 
@@ -16,7 +17,9 @@ import (
 	imagesapi "github.com/containerd/containerd/api/services/images"
 	snapshotapi "github.com/containerd/containerd/api/services/snapshot"
 	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
 	"github.com/containerd/containerd/images"
+	"github.com/containerd/containerd/namespaces"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/containerd/containerd/rootfs"
@@ -27,12 +30,14 @@ import (
 	snapshotservice "github.com/containerd/containerd/services/snapshot"
 	"github.com/containerd/containerd/snapshot"
 	protobuf "github.com/gogo/protobuf/types"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/opencontainers/image-spec/identity"
 	"github.com/opencontainers/image-spec/specs-go/v1"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/grpclog"
+	"google.golang.org/grpc/metadata"
 )
 
 func init() {
@@ -49,6 +54,7 @@ func New(address string, opts ...NewClientOpts) (*Client, error) {
 		grpc.WithInsecure(),
 		grpc.WithTimeout(100 * time.Second),
 		grpc.WithDialer(dialer),
+		grpc.WithUnaryInterceptor(namespaceInterceptor),
 	}
 	conn, err := grpc.Dial(dialAddress(address), gopts...)
 	if err != nil {
@@ -66,20 +72,72 @@ func New(address string, opts ...NewClientOpts) (*Client, error) {
 	return c, nil
 }
 
+// WithNamespace sets the namespace the client defaults to for calls whose
+// context does not already carry one (see Client.WithNamespace and
+// namespaces.WithNamespace).
+func WithNamespace(ns string) NewClientOpts {
+	return func(c *Client) error {
+		c.namespace = ns
+		return nil
+	}
+}
+
 // Client is the client to interact with containerd and its various services
 // using a uniform interface
 type Client struct {
 	conn *grpc.ClientConn
 
 	Runtime string
+
+	// namespace is used by requireNamespace to scope calls whose context
+	// does not already carry one.
+	namespace string
+}
+
+// WithNamespace returns a shallow copy of the client that defaults to ns for
+// calls whose context does not already carry a namespace.
+func (c *Client) WithNamespace(ns string) *Client {
+	nc := *c
+	nc.namespace = ns
+	return &nc
+}
+
+// requireNamespace returns ctx, adding the client's default namespace if ctx
+// does not already carry one. It errors if neither is set, so that
+// multi-tenant callers get a clear failure instead of an ambiguous one from
+// the server.
+func (c *Client) requireNamespace(ctx context.Context) (context.Context, error) {
+	if _, ok := namespaces.Namespace(ctx); ok {
+		return ctx, nil
+	}
+	if c.namespace == "" {
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "no namespace on the context and none set on the client")
+	}
+	return namespaces.WithNamespace(ctx, c.namespace), nil
+}
+
+// namespaceInterceptor attaches the namespace carried on ctx, if any, as the
+// containerd-namespace gRPC metadata header on every call made through the
+// client's connection.
+func namespaceInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if ns, ok := namespaces.Namespace(ctx); ok {
+		ctx = metadata.AppendToOutgoingContext(ctx, namespaces.GRPCHeader, ns)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
 }
 
-// Containers returns all containers created in containerd
+// Containers returns all containers created in containerd within the
+// context's namespace, or the client's default namespace if the context
+// carries none.
 func (c *Client) Containers(ctx context.Context) ([]*Container, error) {
-	r, err := c.containers().List(ctx, &containers.ListContainersRequest{})
+	ctx, err := c.requireNamespace(ctx)
 	if err != nil {
 		return nil, err
 	}
+	r, err := c.containers().List(ctx, &containers.ListContainersRequest{})
+	if err != nil {
+		return nil, errdefs.FromGRPC(err)
+	}
 	var out []*Container
 	for _, container := range r.Containers {
 		out = append(out, containerFromProto(c, container))
@@ -102,40 +160,71 @@ func NewContainerWithExistingRootFS(id string) NewContainerOpts {
 	return func(ctx context.Context, client *Client, c *containers.Container) error {
 		// check that the snapshot exists, if not, fail on creation
 		if _, err := client.snapshotter().Mounts(ctx, id); err != nil {
-			return err
+			return errdefs.FromGRPC(err)
 		}
 		c.RootFS = id
 		return nil
 	}
 }
 
-// NewContainerWithNewRootFS allocates a new snapshot to be used by the container as the
-// root filesystem in read-write mode
+// NewContainerWithNewRootFS allocates a new snapshot to be used by the
+// container as the root filesystem in read-write mode. If image was pulled
+// with WithPullAllPlatforms, the rootfs for the host platform is used; use
+// NewContainerWithNewRootFSForPlatform to pick a different one.
 func NewContainerWithNewRootFS(id string, image *Image) NewContainerOpts {
+	return NewContainerWithNewRootFSForPlatform(id, image, hostPlatform())
+}
+
+// NewContainerWithNewRootFSForPlatform is like NewContainerWithNewRootFS, but
+// selects the rootfs of the given platform out of a multi-platform image
+// pulled with WithPullAllPlatforms.
+func NewContainerWithNewRootFSForPlatform(id string, image *Image, platform v1.Platform) NewContainerOpts {
 	return func(ctx context.Context, client *Client, c *containers.Container) error {
-		diffIDs, err := image.i.RootFS(ctx, client.content())
+		i := image.i
+		target, err := resolveManifestForPlatform(ctx, client.content(), i.Target, platform)
 		if err != nil {
 			return err
 		}
-		if _, err := client.snapshotter().Prepare(ctx, id, identity.ChainID(diffIDs).String()); err != nil {
+		i.Target = target
+		diffIDs, err := i.RootFS(ctx, client.content())
+		if err != nil {
 			return err
 		}
+		if _, err := client.snapshotter().Prepare(ctx, id, identity.ChainID(diffIDs).String()); err != nil {
+			return errdefs.FromGRPC(err)
+		}
 		c.RootFS = id
 		return nil
 	}
 }
 
-// NewContainerWithNewReadonlyRootFS allocates a new snapshot to be used by the container as the
-// root filesystem in read-only mode
+// NewContainerWithNewReadonlyRootFS allocates a new snapshot to be used by
+// the container as the root filesystem in read-only mode. If image was
+// pulled with WithPullAllPlatforms, the rootfs for the host platform is
+// used; use NewContainerWithNewReadonlyRootFSForPlatform to pick a different
+// one.
 func NewContainerWithNewReadonlyRootFS(id string, image *Image) NewContainerOpts {
+	return NewContainerWithNewReadonlyRootFSForPlatform(id, image, hostPlatform())
+}
+
+// NewContainerWithNewReadonlyRootFSForPlatform is like
+// NewContainerWithNewReadonlyRootFS, but selects the rootfs of the given
+// platform out of a multi-platform image pulled with WithPullAllPlatforms.
+func NewContainerWithNewReadonlyRootFSForPlatform(id string, image *Image, platform v1.Platform) NewContainerOpts {
 	return func(ctx context.Context, client *Client, c *containers.Container) error {
-		diffIDs, err := image.i.RootFS(ctx, client.content())
+		i := image.i
+		target, err := resolveManifestForPlatform(ctx, client.content(), i.Target, platform)
 		if err != nil {
 			return err
 		}
-		if _, err := client.snapshotter().View(ctx, id, identity.ChainID(diffIDs).String()); err != nil {
+		i.Target = target
+		diffIDs, err := i.RootFS(ctx, client.content())
+		if err != nil {
 			return err
 		}
+		if _, err := client.snapshotter().View(ctx, id, identity.ChainID(diffIDs).String()); err != nil {
+			return errdefs.FromGRPC(err)
+		}
 		c.RootFS = id
 		return nil
 	}
@@ -149,8 +238,14 @@ func NewContainerWithRuntime(name string) NewContainerOpts {
 }
 
 // NewContainer will create a new container in container with the provided id
-// the id must be unique within the namespace
+// the id must be unique within the namespace. The container is created in
+// the context's namespace, or the client's default namespace if the context
+// carries none.
 func (c *Client) NewContainer(ctx context.Context, id string, spec *specs.Spec, opts ...NewContainerOpts) (*Container, error) {
+	ctx, err := c.requireNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
 	data, err := json.Marshal(spec)
 	if err != nil {
 		return nil, err
@@ -172,26 +267,291 @@ func (c *Client) NewContainer(ctx context.Context, id string, spec *specs.Spec,
 		Container: container,
 	})
 	if err != nil {
-		return nil, err
+		return nil, errdefs.FromGRPC(err)
 	}
 	return containerFromProto(c, r.Container), nil
 }
 
 type PullOpts func(*Client, *PullContext) error
 
+// PullCredentialsFunc resolves the basic auth credentials to use against the
+// given registry host.
+type PullCredentialsFunc func(host string) (user, secret string, err error)
+
 type PullContext struct {
 	Resolver remotes.Resolver
 	Unpacker Unpacker
+
+	// Mirrors is a list of registry host endpoints that are tried, in
+	// order, before falling back to the ref's own registry.
+	Mirrors []string
+	// Credentials resolves basic auth credentials for a registry host,
+	// including mirrors.
+	Credentials PullCredentialsFunc
+	// MountFrom lists repositories that blobs may be cross-repository
+	// mounted from instead of fetched, when the registry supports it.
+	MountFrom []string
+
+	// Concurrency is the number of layers fetched and extracted in
+	// parallel. Defaults to defaultPullConcurrency.
+	Concurrency int
+	// ProgressReporter, if set, receives PullProgress events as the pull
+	// proceeds.
+	ProgressReporter ProgressReporter
+
+	// Platforms restricts which platform-specific manifests of an image
+	// index are fetched and unpacked. Defaults to the host platform, as
+	// derived from runtime.GOOS/runtime.GOARCH.
+	Platforms []v1.Platform
+	// AllPlatforms, if set, fetches and unpacks every manifest referenced
+	// by an image index instead of only those matching Platforms.
+	AllPlatforms bool
 }
 
 func defaultPullContext() *PullContext {
-	return &PullContext{
-		Resolver: docker.NewResolver(docker.ResolverOptions{
-			Client: http.DefaultClient,
-		}),
+	return &PullContext{}
+}
+
+// WithPullMirrors configures a list of mirror endpoints that are attempted,
+// in order, before the ref's own registry when resolving and fetching.
+func WithPullMirrors(mirrors []string) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.Mirrors = mirrors
+		return nil
+	}
+}
+
+// WithPullCredentials sets the credentials function used to authenticate
+// against the ref's registry as well as any configured mirrors.
+func WithPullCredentials(creds PullCredentialsFunc) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.Credentials = creds
+		return nil
+	}
+}
+
+// WithPullMountFrom enables cross-repository blob mounts from the given
+// repositories before falling back to a normal fetch.
+func WithPullMountFrom(repos []string) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.MountFrom = repos
+		return nil
 	}
 }
 
+// WithPullPlatforms restricts a pull of an image index to the given
+// platforms, instead of the host platform.
+func WithPullPlatforms(platforms ...v1.Platform) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.Platforms = platforms
+		return nil
+	}
+}
+
+// WithPullAllPlatforms fetches and unpacks every manifest referenced by an
+// image index, rather than only the ones matching the host platform.
+func WithPullAllPlatforms() PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.AllPlatforms = true
+		return nil
+	}
+}
+
+// hostPlatform returns the platform of the host this process is running on.
+func hostPlatform() v1.Platform {
+	return v1.Platform{
+		OS:           runtime.GOOS,
+		Architecture: runtime.GOARCH,
+	}
+}
+
+// matchesPlatform reports whether candidate satisfies platform, comparing OS,
+// Architecture, and Variant (when platform specifies one).
+func matchesPlatform(platform, candidate v1.Platform) bool {
+	if platform.OS != candidate.OS || platform.Architecture != candidate.Architecture {
+		return false
+	}
+	return platform.Variant == "" || platform.Variant == candidate.Variant
+}
+
+// dockerManifestListMediaType is the legacy Docker equivalent of
+// v1.MediaTypeImageIndex.
+const dockerManifestListMediaType = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+// isIndexMediaType reports whether mediaType identifies an image index or a
+// Docker manifest list.
+func isIndexMediaType(mediaType string) bool {
+	return mediaType == v1.MediaTypeImageIndex || mediaType == dockerManifestListMediaType
+}
+
+// platformAnnotationPrefix namespaces the annotations used to persist a
+// platform->manifest mapping on a stored image index, so that
+// NewContainerWithNewRootFS and friends can later find the manifest for a
+// given platform without re-walking the index (though resolveManifestForPlatform
+// still confirms the manifest is present in the content store, since an
+// index's children cannot change once pulled).
+const platformAnnotationPrefix = "io.containerd.image.platform."
+
+// platformKey returns a stable, human-readable key for platform, suitable
+// for use as an annotation key suffix or a log field.
+func platformKey(platform v1.Platform) string {
+	if platform.Variant != "" {
+		return platform.OS + "/" + platform.Architecture + "/" + platform.Variant
+	}
+	return platform.OS + "/" + platform.Architecture
+}
+
+// annotatePlatformManifests records, on index's own Annotations, the full
+// descriptor serving each platform in manifests, encoded as JSON. This is
+// the platform->manifest mapping persisted on the stored images.Image so
+// that resolveManifestForPlatform does not need to re-fetch and re-parse
+// the index blob just to find the manifest for a given platform.
+func annotatePlatformManifests(index *v1.Descriptor, manifests []v1.Descriptor) {
+	if index.Annotations == nil {
+		index.Annotations = make(map[string]string)
+	}
+	for _, m := range manifests {
+		if m.Platform == nil {
+			continue
+		}
+		encoded, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		index.Annotations[platformAnnotationPrefix+platformKey(*m.Platform)] = string(encoded)
+	}
+}
+
+// resolveManifestForPlatform returns the manifest descriptor serving
+// platform out of target. If target is already a single manifest (not an
+// index), it is returned unchanged. If target carries an annotation from
+// annotatePlatformManifests for platform, that descriptor is used directly
+// (after confirming the manifest is still in store) instead of re-walking
+// the index.
+func resolveManifestForPlatform(ctx context.Context, store content.Store, target v1.Descriptor, platform v1.Platform) (v1.Descriptor, error) {
+	if !isIndexMediaType(target.MediaType) {
+		return target, nil
+	}
+	if encoded, ok := target.Annotations[platformAnnotationPrefix+platformKey(platform)]; ok {
+		var manifest v1.Descriptor
+		if err := json.Unmarshal([]byte(encoded), &manifest); err == nil {
+			if _, err := store.Info(ctx, manifest.Digest); err == nil {
+				return manifest, nil
+			}
+		}
+	}
+	manifests, err := selectManifests(ctx, store, target, []v1.Platform{platform})
+	if err != nil {
+		return v1.Descriptor{}, err
+	}
+	if len(manifests) == 0 {
+		return v1.Descriptor{}, errors.Wrapf(errdefs.ErrNotFound, "no manifest in index %s matches platform %s", target.Digest, platformKey(platform))
+	}
+	return manifests[0], nil
+}
+
+// selectManifests reads the index at desc and returns the child manifest
+// descriptors matching platforms, or every child manifest if platforms is
+// empty.
+func selectManifests(ctx context.Context, store content.Store, desc v1.Descriptor, wanted []v1.Platform) ([]v1.Descriptor, error) {
+	p, err := content.ReadBlob(ctx, store, desc.Digest)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read index blob")
+	}
+	var index v1.Index
+	if err := json.Unmarshal(p, &index); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal index")
+	}
+	if len(wanted) == 0 {
+		return index.Manifests, nil
+	}
+	var matched []v1.Descriptor
+	for _, m := range index.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		for _, platform := range wanted {
+			if matchesPlatform(platform, *m.Platform) {
+				matched = append(matched, m)
+				break
+			}
+		}
+	}
+	return matched, nil
+}
+
+// indexAwareChildrenHandler wraps images.ChildrenHandler so that when it is
+// asked for the children of an image index, it returns only the manifests
+// matching pullCtx's platform selection (all of them if AllPlatforms is set)
+// instead of every manifest in the index.
+func indexAwareChildrenHandler(store content.Store, pullCtx *PullContext) images.Handler {
+	children := images.ChildrenHandler(store)
+	return images.HandlerFunc(func(ctx context.Context, desc v1.Descriptor) ([]v1.Descriptor, error) {
+		if !isIndexMediaType(desc.MediaType) || pullCtx.AllPlatforms {
+			return children.Handle(ctx, desc)
+		}
+		wanted := pullCtx.Platforms
+		if len(wanted) == 0 {
+			wanted = []v1.Platform{hostPlatform()}
+		}
+		return selectManifests(ctx, store, desc, wanted)
+	})
+}
+
+// mirrorResolver tries Resolve against each mirror, in order, falling back
+// to the ref's own registry if every mirror fails. Fetcher is served from
+// whichever resolver most recently succeeded at Resolve, so a ref resolved
+// through a mirror is also fetched from that mirror.
+type mirrorResolver struct {
+	mirrors  []remotes.Resolver
+	fallback remotes.Resolver
+
+	resolved remotes.Resolver
+}
+
+func (m *mirrorResolver) Resolve(ctx context.Context, ref string) (string, v1.Descriptor, error) {
+	var lastErr error
+	for _, r := range append(append([]remotes.Resolver{}, m.mirrors...), m.fallback) {
+		name, desc, err := r.Resolve(ctx, ref)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		m.resolved = r
+		return name, desc, nil
+	}
+	return "", v1.Descriptor{}, lastErr
+}
+
+func (m *mirrorResolver) Fetcher(ctx context.Context, ref string) (remotes.Fetcher, error) {
+	if m.resolved != nil {
+		return m.resolved.Fetcher(ctx, ref)
+	}
+	return m.fallback.Fetcher(ctx, ref)
+}
+
+// resolverFromPullContext builds the remotes.Resolver to use for a pull,
+// wiring in any configured mirrors and credentials. Mirrors are tried, in
+// order, before falling back to the ref's own registry.
+func resolverFromPullContext(c *PullContext) remotes.Resolver {
+	fallback := docker.NewResolver(docker.ResolverOptions{
+		Client:      http.DefaultClient,
+		Credentials: c.Credentials,
+	})
+	if len(c.Mirrors) == 0 {
+		return fallback
+	}
+	mirrors := make([]remotes.Resolver, len(c.Mirrors))
+	for i, host := range c.Mirrors {
+		mirrors[i] = docker.NewResolver(docker.ResolverOptions{
+			Client:      http.DefaultClient,
+			Hosts:       []string{host},
+			Credentials: c.Credentials,
+		})
+	}
+	return &mirrorResolver{mirrors: mirrors, fallback: fallback}
+}
+
 func WithPullUnpack(client *Client, c *PullContext) error {
 	c.Unpacker = &snapshotUnpacker{
 		store:       client.content(),
@@ -201,6 +561,88 @@ func WithPullUnpack(client *Client, c *PullContext) error {
 	return nil
 }
 
+// defaultPullConcurrency is the number of layers fetched in parallel when no
+// WithPullConcurrency option is given.
+const defaultPullConcurrency = 3
+
+// PullPhase describes the stage of a layer pull that a PullProgress event
+// refers to.
+type PullPhase string
+
+const (
+	PullPhaseResolving   PullPhase = "resolving"
+	PullPhaseDownloading PullPhase = "downloading"
+	PullPhaseExtracting  PullPhase = "extracting"
+	PullPhaseDone        PullPhase = "done"
+)
+
+// PullProgress reports the state of a single layer as a pull progresses.
+type PullProgress struct {
+	Digest digest.Digest
+	Ref    string
+	Phase  PullPhase
+	Offset int64
+	Total  int64
+	Err    error
+	// Endpoint is the registry host that served (or was being fetched
+	// from, for in-progress events) this layer, when the fetcher can
+	// report one. Empty if the fetcher does not implement fetcherEndpoint.
+	Endpoint string
+}
+
+// ProgressReporter receives PullProgress events as a pull proceeds. It is
+// called from multiple goroutines and must be safe for concurrent use.
+type ProgressReporter interface {
+	Report(PullProgress)
+}
+
+type progressReporterFunc func(PullProgress)
+
+func (f progressReporterFunc) Report(p PullProgress) {
+	f(p)
+}
+
+// WithPullConcurrency sets the number of layers fetched and extracted in
+// parallel. The default is defaultPullConcurrency.
+func WithPullConcurrency(n int) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.Concurrency = n
+		return nil
+	}
+}
+
+// WithPullProgress streams PullProgress events for each layer to ch as the
+// pull proceeds. Sends are dropped if ch is not read from promptly enough to
+// keep up with the pull; callers needing every event should buffer ch.
+func WithPullProgress(ch chan<- PullProgress) PullOpts {
+	return func(_ *Client, c *PullContext) error {
+		c.ProgressReporter = progressReporterFunc(func(p PullProgress) {
+			select {
+			case ch <- p:
+			default:
+			}
+		})
+		return nil
+	}
+}
+
+type pullContextKey struct{}
+
+func withPullContext(ctx context.Context, c *PullContext) context.Context {
+	return context.WithValue(ctx, pullContextKey{}, c)
+}
+
+func pullContextFromContext(ctx context.Context) *PullContext {
+	c, _ := ctx.Value(pullContextKey{}).(*PullContext)
+	return c
+}
+
+func reportProgress(ctx context.Context, p PullProgress) {
+	if c := pullContextFromContext(ctx); c != nil && c.ProgressReporter != nil {
+		c.ProgressReporter.Report(p)
+	}
+}
+
 type Unpacker interface {
 	Unpack(context.Context, images.Image) error
 }
@@ -211,32 +653,120 @@ type snapshotUnpacker struct {
 	diff        diff.DiffService
 }
 
+// Unpack applies each layer's diff to the snapshotter in chain order. Layers
+// are downloaded ahead of time by Client.Pull's fetch handlers, but are
+// applied one at a time here so that a layer's parent is always committed
+// before it is used as a base. If image's target is an image index, every
+// manifest selected by the pull's platform options is unpacked in turn
+// (all of them, under WithPullAllPlatforms).
 func (s *snapshotUnpacker) Unpack(ctx context.Context, image images.Image) error {
-	layers, err := s.getLayers(ctx, image)
+	manifests, err := s.manifestsToUnpack(ctx, image)
 	if err != nil {
 		return err
 	}
-	if _, err := rootfs.ApplyLayers(ctx, layers, s.snapshotter, s.diff); err != nil {
-		return err
+	for _, m := range manifests {
+		layers, err := s.getLayers(ctx, m)
+		if err != nil {
+			return err
+		}
+		var chainID string
+		for i := range layers {
+			reportProgress(ctx, PullProgress{
+				Digest: layers[i].Diff.Digest,
+				Phase:  PullPhaseExtracting,
+			})
+			chainID, err = s.applyLayer(ctx, chainID, layers[i])
+			if err != nil {
+				return err
+			}
+			reportProgress(ctx, PullProgress{
+				Digest: layers[i].Diff.Digest,
+				Phase:  PullPhaseDone,
+			})
+		}
 	}
 	return nil
 }
 
+// applyLayer applies a single layer on top of parentChainID, returning the
+// chain ID of the new top layer. Unlike calling rootfs.ApplyLayers with a
+// growing prefix of the chain on every layer, this only ever prepares,
+// diffs, and commits the one new layer, so unpacking an N-layer image does
+// O(N) snapshot operations rather than O(N^2). If the resulting chain ID is
+// already present in the snapshotter (e.g. shared with another image), the
+// layer is skipped rather than re-applied.
+func (s *snapshotUnpacker) applyLayer(ctx context.Context, parentChainID string, layer rootfs.Layer) (string, error) {
+	var chainID string
+	if parentChainID == "" {
+		chainID = layer.Diff.Digest.String()
+	} else {
+		chainID = identity.ChainID([]digest.Digest{digest.Digest(parentChainID), layer.Diff.Digest}).String()
+	}
+
+	if _, err := s.snapshotter.Stat(ctx, chainID); err == nil {
+		return chainID, nil
+	}
+
+	key := "extract-" + chainID
+	mounts, err := s.snapshotter.Prepare(ctx, key, parentChainID)
+	if err != nil {
+		return "", errdefs.FromGRPC(err)
+	}
+	if _, err := s.diff.Apply(ctx, layer.Blob, mounts); err != nil {
+		return "", errdefs.FromGRPC(err)
+	}
+	if err := s.snapshotter.Commit(ctx, chainID, key); err != nil {
+		return "", errdefs.FromGRPC(err)
+	}
+	return chainID, nil
+}
+
+// manifestsToUnpack resolves image's target to the one or more concrete
+// manifests that should be unpacked, consulting the PullContext's platform
+// selection carried on ctx. If image.Target is already a single manifest, it
+// is returned unchanged.
+func (s *snapshotUnpacker) manifestsToUnpack(ctx context.Context, image images.Image) ([]images.Image, error) {
+	if !isIndexMediaType(image.Target.MediaType) {
+		return []images.Image{image}, nil
+	}
+	var wanted []v1.Platform
+	if pullCtx := pullContextFromContext(ctx); pullCtx == nil || !pullCtx.AllPlatforms {
+		wanted = []v1.Platform{hostPlatform()}
+		if pullCtx != nil && len(pullCtx.Platforms) > 0 {
+			wanted = pullCtx.Platforms
+		}
+	}
+	manifests, err := selectManifests(ctx, s.store, image.Target, wanted)
+	if err != nil {
+		return nil, err
+	}
+	if len(manifests) == 0 {
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "no manifest in index %s matches platform(s) %v", image.Target.Digest, wanted)
+	}
+	out := make([]images.Image, len(manifests))
+	for i, m := range manifests {
+		out[i] = image
+		out[i].Target = m
+	}
+	return out, nil
+}
+
 func (s *snapshotUnpacker) getLayers(ctx context.Context, image images.Image) ([]rootfs.Layer, error) {
-	p, err := content.ReadBlob(ctx, s.store, image.Target.Digest)
+	manifestDigest := image.Target.Digest
+	p, err := content.ReadBlob(ctx, s.store, manifestDigest)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to read manifest blob")
+		return nil, errors.Wrapf(errdefs.ErrNotFound, "failed to read manifest blob: %v", err)
 	}
 	var manifest v1.Manifest
 	if err := json.Unmarshal(p, &manifest); err != nil {
-		return nil, errors.Wrap(err, "failed to unmarshal manifest")
+		return nil, errors.Wrapf(errdefs.ErrInvalidArgument, "failed to unmarshal manifest: %v", err)
 	}
 	diffIDs, err := image.RootFS(ctx, s.store)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to resolve rootfs")
 	}
 	if len(diffIDs) != len(manifest.Layers) {
-		return nil, errors.Errorf("mismatched image rootfs and manifest layers")
+		return nil, errors.Wrap(errdefs.ErrInvalidArgument, "mismatched image rootfs and manifest layers")
 	}
 	layers := make([]rootfs.Layer, len(diffIDs))
 	for i := range diffIDs {
@@ -250,15 +780,152 @@ func (s *snapshotUnpacker) getLayers(ctx context.Context, image images.Image) ([
 	return layers, nil
 }
 
+// mountFetcher is implemented by fetchers that can cross-repository mount a
+// blob from another repository instead of downloading it.
+type mountFetcher interface {
+	remotes.Fetcher
+	Mount(ctx context.Context, desc v1.Descriptor, from string) error
+}
+
+// fetcherEndpoint is implemented by fetchers that can report which registry
+// host endpoint they are bound to, such as the docker resolver's fetcher,
+// so Pull can surface it on PullProgress for observability.
+type fetcherEndpoint interface {
+	Endpoint() string
+}
+
+// newMountingFetchHandler returns a handler that, for each descriptor, tries
+// a cross-repository mount from mountFrom (in order) before falling back to
+// a normal fetch, retrying fetches on 5xx/timeout errors with backoff. The
+// returned handler reports PullProgress events via the PullContext stored on
+// ctx (see withPullContext) and limits the number of concurrent fetches to
+// the configured concurrency.
+func newMountingFetchHandler(store content.Store, fetcher remotes.Fetcher, mountFrom []string, concurrency int) images.Handler {
+	fetch := retryingHandler(remotes.FetchHandler(store, fetcher))
+	mf, hasMounter := fetcher.(mountFetcher)
+
+	var endpoint string
+	if fe, ok := fetcher.(fetcherEndpoint); ok {
+		endpoint = fe.Endpoint()
+	}
+
+	if concurrency <= 0 {
+		concurrency = defaultPullConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+
+	return images.HandlerFunc(func(ctx context.Context, desc v1.Descriptor) ([]v1.Descriptor, error) {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-sem }()
+
+		if len(mountFrom) > 0 && hasMounter {
+			for _, from := range mountFrom {
+				if err := mf.Mount(ctx, desc, from); err == nil {
+					reportProgress(ctx, PullProgress{Digest: desc.Digest, Phase: PullPhaseDone, Total: desc.Size, Endpoint: endpoint})
+					return nil, nil
+				}
+			}
+		}
+
+		offset := ingestOffset(ctx, store, desc)
+		reportProgress(ctx, PullProgress{Digest: desc.Digest, Phase: PullPhaseDownloading, Offset: offset, Total: desc.Size, Endpoint: endpoint})
+		children, err := fetch.Handle(ctx, desc)
+		reportProgress(ctx, PullProgress{Digest: desc.Digest, Phase: PullPhaseDone, Total: desc.Size, Err: err, Endpoint: endpoint})
+		return children, err
+	})
+}
+
+// ingestOffset returns the number of bytes already written to the content
+// store's ingest for desc, for progress reporting only. This package does
+// not itself issue an HTTP Range request against this offset: whether a
+// re-fetch of a partially ingested blob actually resumes rather than starts
+// over depends entirely on the remotes.Fetcher implementation passed to
+// Pull (through PullContext.Resolver), which is outside this package.
+func ingestOffset(ctx context.Context, store content.Store, desc v1.Descriptor) int64 {
+	status, err := store.Status(ctx, remotes.MakeRefKey(ctx, desc))
+	if err != nil {
+		return 0
+	}
+	return status.Offset
+}
+
+// retryingHandler wraps h, retrying with exponential backoff when it fails
+// with a retriable (5xx or timeout) error.
+func retryingHandler(h images.Handler) images.Handler {
+	const maxAttempts = 3
+	return images.HandlerFunc(func(ctx context.Context, desc v1.Descriptor) ([]v1.Descriptor, error) {
+		backoff := 500 * time.Millisecond
+		var lastErr error
+		for attempt := 0; attempt < maxAttempts; attempt++ {
+			children, err := h.Handle(ctx, desc)
+			if err == nil {
+				return children, nil
+			}
+			lastErr = err
+			if !isRetriableFetchErr(err) {
+				return nil, err
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		return nil, lastErr
+	})
+}
+
+// httpStatusError is implemented by fetch errors that carry the HTTP status
+// code of the failed request, such as the unexpected-status errors returned
+// by the docker resolver's fetcher.
+type httpStatusError interface {
+	StatusCode() int
+}
+
+// isRetriableFetchErr reports whether err looks like a transient 5xx or
+// timeout error worth retrying against a mirror.
+func isRetriableFetchErr(err error) bool {
+	if err == nil {
+		return false
+	}
+	cause := errors.Cause(err)
+	if cause == context.DeadlineExceeded {
+		return true
+	}
+	if uerr, ok := cause.(*url.Error); ok {
+		return uerr.Timeout()
+	}
+	if herr, ok := cause.(httpStatusError); ok {
+		return herr.StatusCode() >= 500 && herr.StatusCode() < 600
+	}
+	return false
+}
+
+// Pull resolves, fetches, and stores an image in the context's namespace, or
+// the client's default namespace if the context carries none.
 func (c *Client) Pull(ctx context.Context, ref string, opts ...PullOpts) (*Image, error) {
+	ctx, err := c.requireNamespace(ctx)
+	if err != nil {
+		return nil, err
+	}
 	pullCtx := defaultPullContext()
 	for _, o := range opts {
 		if err := o(c, pullCtx); err != nil {
 			return nil, err
 		}
 	}
+	if pullCtx.Resolver == nil {
+		pullCtx.Resolver = resolverFromPullContext(pullCtx)
+	}
+	ctx = withPullContext(ctx, pullCtx)
 	store := c.content()
 
+	reportProgress(ctx, PullProgress{Ref: ref, Phase: PullPhaseResolving})
 	name, desc, err := pullCtx.Resolver.Resolve(ctx, ref)
 	if err != nil {
 		return nil, err
@@ -269,19 +936,48 @@ func (c *Client) Pull(ctx context.Context, ref string, opts ...PullOpts) (*Image
 	}
 
 	handlers := []images.Handler{
-		remotes.FetchHandler(store, fetcher),
-		images.ChildrenHandler(store),
+		newMountingFetchHandler(store, fetcher, pullCtx.MountFrom, pullCtx.Concurrency),
+		indexAwareChildrenHandler(store, pullCtx),
 	}
 	if err := images.Dispatch(ctx, images.Handlers(handlers...), desc); err != nil {
 		return nil, err
 	}
+
+	target := desc
+	if isIndexMediaType(desc.MediaType) {
+		if pullCtx.AllPlatforms {
+			all, err := selectManifests(ctx, store, desc, nil)
+			if err != nil {
+				return nil, err
+			}
+			// Keep the index itself as the stored target, but record which
+			// manifest serves each platform so a later rootfs selection
+			// (e.g. NewContainerWithNewRootFSForPlatform) doesn't have to
+			// guess.
+			annotatePlatformManifests(&target, all)
+		} else {
+			wanted := pullCtx.Platforms
+			if len(wanted) == 0 {
+				wanted = []v1.Platform{hostPlatform()}
+			}
+			manifests, err := selectManifests(ctx, store, desc, wanted)
+			if err != nil {
+				return nil, err
+			}
+			if len(manifests) == 0 {
+				return nil, errors.Wrapf(errdefs.ErrNotFound, "no manifest in %q matches platform(s) %v", ref, wanted)
+			}
+			target = manifests[0]
+		}
+	}
+
 	is := c.images()
-	if err := is.Put(ctx, name, desc); err != nil {
-		return nil, err
+	if err := is.Put(ctx, name, target); err != nil {
+		return nil, errdefs.FromGRPC(err)
 	}
 	i, err := is.Get(ctx, name)
 	if err != nil {
-		return nil, err
+		return nil, errdefs.FromGRPC(err)
 	}
 	if pullCtx.Unpacker != nil {
 		if err := pullCtx.Unpacker.Unpack(ctx, i); err != nil {
@@ -0,0 +1,112 @@
+// Package errdefs defines the common error types used across containerd
+// client APIs, in a way that is resilient to being wrapped with
+// github.com/pkg/errors and still classifiable by callers without having to
+// string-match error messages.
+package errdefs
+
+import (
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// The root error types that every typed error returned by the client API is
+// (possibly wrapped around) one of. Use the Is* helpers below rather than
+// comparing against these directly, since callers commonly receive a
+// wrapped error.
+var (
+	ErrNotFound        = errors.New("not found")
+	ErrAlreadyExists   = errors.New("already exists")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrUnauthorized    = errors.New("unauthorized")
+	ErrUnavailable     = errors.New("unavailable")
+	ErrCanceled        = errors.New("canceled")
+)
+
+// IsNotFound returns true if err is, or wraps, ErrNotFound.
+func IsNotFound(err error) bool {
+	return errors.Cause(err) == ErrNotFound
+}
+
+// IsAlreadyExists returns true if err is, or wraps, ErrAlreadyExists.
+func IsAlreadyExists(err error) bool {
+	return errors.Cause(err) == ErrAlreadyExists
+}
+
+// IsInvalidArgument returns true if err is, or wraps, ErrInvalidArgument.
+func IsInvalidArgument(err error) bool {
+	return errors.Cause(err) == ErrInvalidArgument
+}
+
+// IsUnauthorized returns true if err is, or wraps, ErrUnauthorized.
+func IsUnauthorized(err error) bool {
+	return errors.Cause(err) == ErrUnauthorized
+}
+
+// IsUnavailable returns true if err is, or wraps, ErrUnavailable.
+func IsUnavailable(err error) bool {
+	return errors.Cause(err) == ErrUnavailable
+}
+
+// IsCanceled returns true if err is, or wraps, ErrCanceled.
+func IsCanceled(err error) bool {
+	return errors.Cause(err) == ErrCanceled
+}
+
+// FromGRPC returns the typed error matching the gRPC status code carried by
+// err, wrapped with err's message, so that callers of containers, content,
+// snapshot, images, and diff clients can classify failures without
+// string-matching. Errors that are nil, or that do not carry a recognized
+// gRPC status, are returned unchanged.
+func FromGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	var cause error
+	switch s.Code() {
+	case codes.NotFound:
+		cause = ErrNotFound
+	case codes.AlreadyExists:
+		cause = ErrAlreadyExists
+	case codes.InvalidArgument:
+		cause = ErrInvalidArgument
+	case codes.Unauthenticated, codes.PermissionDenied:
+		cause = ErrUnauthorized
+	case codes.Unavailable:
+		cause = ErrUnavailable
+	case codes.Canceled:
+		cause = ErrCanceled
+	default:
+		return err
+	}
+	return errors.Wrap(cause, s.Message())
+}
+
+// ToGRPC maps a typed error back onto the matching gRPC status code, for use
+// on the server side of these same services. Errors that do not wrap one of
+// the types above are returned unchanged.
+func ToGRPC(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch errors.Cause(err) {
+	case ErrNotFound:
+		return status.Error(codes.NotFound, err.Error())
+	case ErrAlreadyExists:
+		return status.Error(codes.AlreadyExists, err.Error())
+	case ErrInvalidArgument:
+		return status.Error(codes.InvalidArgument, err.Error())
+	case ErrUnauthorized:
+		return status.Error(codes.Unauthenticated, err.Error())
+	case ErrUnavailable:
+		return status.Error(codes.Unavailable, err.Error())
+	case ErrCanceled:
+		return status.Error(codes.Canceled, err.Error())
+	default:
+		return err
+	}
+}
@@ -0,0 +1,52 @@
+package errdefs
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromGRPCClassification(t *testing.T) {
+	for _, tc := range []struct {
+		code codes.Code
+		is   func(error) bool
+	}{
+		{codes.NotFound, IsNotFound},
+		{codes.AlreadyExists, IsAlreadyExists},
+		{codes.InvalidArgument, IsInvalidArgument},
+		{codes.Unauthenticated, IsUnauthorized},
+		{codes.Unavailable, IsUnavailable},
+		{codes.Canceled, IsCanceled},
+	} {
+		err := FromGRPC(status.Error(tc.code, "boom"))
+		if !tc.is(err) {
+			t.Errorf("code %v: expected classification to hold for %v", tc.code, err)
+		}
+	}
+}
+
+func TestWrappingPreservesClassification(t *testing.T) {
+	err := FromGRPC(status.Error(codes.NotFound, "no such object"))
+	wrapped := errors.Wrap(err, "while fetching object")
+	wrapped = errors.Wrapf(wrapped, "while pulling %s", "some/ref")
+
+	if !IsNotFound(wrapped) {
+		t.Fatalf("expected wrapped error %v to still classify as not found", wrapped)
+	}
+	if IsAlreadyExists(wrapped) {
+		t.Fatalf("expected wrapped error %v not to classify as already exists", wrapped)
+	}
+}
+
+func TestToGRPCRoundTrip(t *testing.T) {
+	err := ToGRPC(errors.Wrap(ErrAlreadyExists, "container already exists"))
+	s, ok := status.FromError(err)
+	if !ok {
+		t.Fatalf("expected a gRPC status error, got %v", err)
+	}
+	if s.Code() != codes.AlreadyExists {
+		t.Fatalf("expected code %v, got %v", codes.AlreadyExists, s.Code())
+	}
+}
@@ -0,0 +1,22 @@
+// Package namespaces defines the namespace associated with a context.Context,
+// allowing a single Client to be shared safely by multiple tenants.
+package namespaces
+
+import "context"
+
+// GRPCHeader is the gRPC metadata header carrying the namespace for a call,
+// attached by the client's namespace interceptor.
+const GRPCHeader = "containerd-namespace"
+
+type namespaceKey struct{}
+
+// WithNamespace returns a copy of ctx carrying namespace.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// Namespace returns the namespace set on ctx, if any.
+func Namespace(ctx context.Context) (string, bool) {
+	namespace, ok := ctx.Value(namespaceKey{}).(string)
+	return namespace, ok
+}